@@ -40,6 +40,7 @@ import (
 	"github.com/urfave/cli/v2"
 
 	"github.com/juicedata/juicefs/pkg/chunk"
+	"github.com/juicedata/juicefs/pkg/compress"
 	"github.com/juicedata/juicefs/pkg/meta"
 	"github.com/juicedata/juicefs/pkg/metric"
 	"github.com/juicedata/juicefs/pkg/usage"
@@ -649,6 +650,7 @@ func mount(c *cli.Context) error {
 		return nil
 	}
 	logger.Infof("JuiceFS version %s", version.Version())
+	logger.Infof("Compression implementation: %s", compress.BuildInfo)
 
 	if commPath := os.Getenv("_FUSE_FD_COMM"); commPath != "" {
 		vfsConf.CommPath = commPath
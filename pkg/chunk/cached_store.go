@@ -356,13 +356,12 @@ func (store *cachedStore) delete(key string) error {
 func (store *cachedStore) upload(ctx context.Context, key string, block *Page, s *wSlice) error {
 	sync := s != nil
 	blen := len(block.Data)
-	bufSize := store.compressor.CompressBound(blen)
 	var buf *Page
-	if bufSize > blen {
-		buf = NewOffPage(bufSize)
-	} else {
+	if store.seekable {
 		buf = block
 		buf.Acquire()
+	} else {
+		buf = NewOffPage(store.compressor.CompressBound(blen))
 	}
 	defer buf.Release()
 	if sync && (blen < store.conf.BlockSize || store.conf.CacheLargeWrite) {
@@ -688,6 +687,7 @@ type cachedStore struct {
 	cacheReadHist       prometheus.Histogram
 	objectReqsHistogram *prometheus.HistogramVec
 	objectReqErrors     prometheus.Counter
+	decompressErrors    prometheus.Counter
 	objectDataBytes     *prometheus.CounterVec
 	stageBlockDelay     prometheus.Counter
 	stageBlockErrors    prometheus.Counter
@@ -762,7 +762,7 @@ func (store *cachedStore) load(ctx context.Context, key string, page *Page, cach
 	store.currentDownload <- struct{}{}
 	defer func() { <-store.currentDownload }()
 	needed := store.compressor.CompressBound(len(page.Data))
-	compressed := needed > len(page.Data)
+	compressed := !store.seekable
 	// we don't know the actual size for compressed block
 	if store.downLimit != nil && !compressed {
 		store.downLimit.Wait(int64(len(page.Data)))
@@ -812,9 +812,15 @@ func (store *cachedStore) load(ctx context.Context, key string, page *Page, cach
 	}
 	if compressed {
 		n, err = store.compressor.Decompress(page.Data, p.Data[:n])
+		if err != nil {
+			store.decompressErrors.Add(1)
+		}
 	}
-	if err != nil || n < len(page.Data) {
-		return fmt.Errorf("read %s fully: %v (%d < %d) after %s", key, err, n, len(page.Data), used)
+	if err != nil {
+		return fmt.Errorf("read %s fully: %w after %s", key, err, used)
+	}
+	if n < len(page.Data) {
+		return fmt.Errorf("read %s fully: %d < %d after %s", key, n, len(page.Data), used)
 	}
 	if cache {
 		store.bcache.cache(key, page, forceCache, !store.conf.OSCache)
@@ -843,7 +849,7 @@ func NewCachedStore(storage object.ObjectStorage, config Config, reg prometheus.
 		currentUpload:   make(chan struct{}, config.MaxUpload),
 		currentDownload: make(chan struct{}, config.MaxDownload),
 		compressor:      compressor,
-		seekable:        compressor.CompressBound(0) == 0,
+		seekable:        compress.IsNoop(compressor),
 		pendingCh:       make(chan *pendingItem, 100*config.MaxUpload),
 		pendingKeys:     make(map[string]*pendingItem),
 		group:           NewController(),
@@ -959,6 +965,10 @@ func (store *cachedStore) initMetrics() {
 		Name: "object_request_errors",
 		Help: "failed requests to object store",
 	})
+	store.decompressErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "object_decompress_errors",
+		Help: "failed to decompress blocks from object store",
+	})
 	store.objectDataBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "object_request_data_bytes",
 		Help: "Object requests size in bytes.",
@@ -984,6 +994,7 @@ func (store *cachedStore) regMetrics(reg prometheus.Registerer) {
 	reg.MustRegister(store.cacheReadHist)
 	reg.MustRegister(store.objectReqsHistogram)
 	reg.MustRegister(store.objectReqErrors)
+	reg.MustRegister(store.decompressErrors)
 	reg.MustRegister(store.objectDataBytes)
 	reg.MustRegister(store.stageBlockDelay)
 	reg.MustRegister(store.stageBlockErrors)
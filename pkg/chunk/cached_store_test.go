@@ -21,6 +21,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -28,8 +29,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/juicedata/juicefs/pkg/compress"
 	"github.com/juicedata/juicefs/pkg/object"
 	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -154,6 +157,88 @@ func TestStoreCompressed(t *testing.T) {
 	testStore(t, store)
 }
 
+// xorCodec is a codec whose output has the same size as its input
+type xorCodec struct{ key byte }
+
+func (x xorCodec) Name() string            { return "XOR" }
+func (x xorCodec) CompressBound(l int) int { return l }
+func (x xorCodec) Compress(dst, src []byte) (int, error) {
+	if len(dst) < len(src) {
+		return 0, fmt.Errorf("%w: %d < %d", compress.ErrBufferTooShort, len(dst), len(src))
+	}
+	for i, b := range src {
+		dst[i] = b ^ x.key
+	}
+	return len(src), nil
+}
+func (x xorCodec) Decompress(dst, src []byte) (int, error) { return x.Compress(dst, src) }
+
+func init() {
+	// registrations are process-global, so register once for the whole package
+	compress.RegisterCompressor("xor", func(string) compress.Compressor { return xorCodec{0x5a} })
+}
+
+func TestStoreCustomCompressor(t *testing.T) {
+	mem, _ := object.CreateStorage("mem", "", "", "", "")
+	conf := defaultConf
+	conf.Compress = "xor"
+	conf.CacheSize = 0
+	store := NewCachedStore(mem, conf, nil)
+	require.False(t, store.(*cachedStore).seekable)
+	testStore(t, store)
+
+	w := store.NewWriter(10, 0)
+	data := []byte("hello world")
+	_, err := w.WriteAt(data, 0)
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(len(data)))
+	defer store.Remove(10, len(data))
+	objs, err := object.ListAll(ctx, mem, "", "", true, false)
+	require.NoError(t, err)
+	for o := range objs {
+		in, err := mem.Get(ctx, o.Key(), 0, -1)
+		require.NoError(t, err)
+		raw, _ := io.ReadAll(in)
+		in.Close()
+		require.NotContains(t, string(raw), "hello")
+	}
+	p := NewPage(make([]byte, 5))
+	defer p.Release()
+	n, err := store.NewReader(10, len(data)).ReadAt(ctx, p, 6)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(p.Data[:n]))
+}
+
+func TestStoreDecompressError(t *testing.T) {
+	mem, _ := object.CreateStorage("mem", "", "", "", "")
+	conf := defaultConf
+	conf.Compress = "zstd"
+	conf.CacheSize = 0
+	store := NewCachedStore(mem, conf, nil).(*cachedStore)
+	w := store.NewWriter(20, 0)
+	data := bytes.Repeat([]byte("hello world "), 100)
+	_, err := w.WriteAt(data, 0)
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(len(data)))
+	defer store.Remove(20, len(data))
+
+	key := store.NewReader(20, len(data)).(*rSlice).key(0)
+	in, err := mem.Get(ctx, key, 0, -1)
+	require.NoError(t, err)
+	raw, _ := io.ReadAll(in)
+	in.Close()
+	for i := len(raw) / 2; i < len(raw); i++ {
+		raw[i] = 0xff
+	}
+	require.NoError(t, mem.Put(ctx, key, bytes.NewReader(raw)))
+
+	p := NewPage(make([]byte, len(data)))
+	defer p.Release()
+	_, err = store.NewReader(20, len(data)).ReadAt(ctx, p, 0)
+	require.ErrorIs(t, err, compress.ErrDecompress)
+	require.Equal(t, 1.0, testutil.ToFloat64(store.decompressErrors))
+}
+
 func TestStoreLimited(t *testing.T) {
 	mem, _ := object.CreateStorage("mem", "", "", "", "")
 	conf := defaultConf
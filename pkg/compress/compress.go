@@ -17,8 +17,13 @@
 package compress
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/DataDog/zstd"
 	"github.com/hungys/go-lz4"
@@ -27,7 +32,10 @@ import (
 // ZSTD_LEVEL compression level used by Zstd
 const ZSTD_LEVEL = 1 // fastest
 
-// Compressor interface to be implemented by a compression algo
+// Compressor interface to be implemented by a compression algo.
+// Compressing an empty input always yields a valid block, which decompresses
+// back to empty output. Decompressing zero bytes is an error unless the
+// algorithm encodes empty input as zero bytes (CompressBound(0) == 0).
 type Compressor interface {
 	Name() string
 	CompressBound(int) int
@@ -35,33 +43,103 @@ type Compressor interface {
 	Decompress(dst, src []byte) (int, error)
 }
 
+var (
+	// ErrDecompress is returned when the input is corrupted or truncated
+	ErrDecompress = errors.New("decompress")
+	// ErrBufferTooShort is returned when the destination buffer can't hold the result
+	ErrBufferTooShort = errors.New("buffer too short")
+	// ErrWrongAlgorithm is returned when the input was not produced by the algorithm,
+	// which may also be a corrupted header, so it matches ErrDecompress too
+	ErrWrongAlgorithm = fmt.Errorf("%w: wrong compression algorithm", ErrDecompress)
+
+	errEmptyInput = fmt.Errorf("%w: empty input", ErrDecompress)
+)
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// BuildInfo describes the libraries behind the built-in compression algorithms,
+// both are cgo bindings and there is no pure-Go alternative to build with.
+const BuildInfo = "zstd: cgo DataDog/zstd, lz4: cgo hungys/go-lz4"
+
+// Creator creates a Compressor with optional algorithm specific parameters
+type Creator func(params string) Compressor
+
+var compressors = make(map[string]Creator)
+
+// RegisterCompressor registers a compression algorithm under the given name,
+// which is matched case-insensitively by NewCompressor. Registrations are
+// process-global and should be done in init().
+// Blocks written by any algorithm other than "none" are treated as opaque:
+// they are always decompressed in full on reads and never read by range,
+// and CompressBound must not be smaller than any compressed output.
+func RegisterCompressor(name string, factory Creator) {
+	compressors[strings.ToLower(name)] = factory
+}
+
+func init() {
+	RegisterCompressor("none", func(params string) Compressor {
+		if params != "" {
+			return nil
+		}
+		return noOp{}
+	})
+	RegisterCompressor("zstd", func(params string) Compressor {
+		level := ZSTD_LEVEL
+		if params != "" {
+			l, err := strconv.Atoi(params)
+			if err != nil {
+				return nil
+			}
+			level = l
+		}
+		return ZStandard{level}
+	})
+	RegisterCompressor("lz4", func(params string) Compressor {
+		if params != "" {
+			return nil
+		}
+		return LZ4{}
+	})
+}
+
 // NewCompressor returns a struct implementing Compressor interface
 func NewCompressor(algr string) Compressor {
+	return NewCompressorWithParams(algr, "")
+}
+
+// NewCompressorWithParams returns the Compressor created with algorithm specific
+// parameters, which are not part of the algorithm name stored in the volume format.
+func NewCompressorWithParams(algr, params string) Compressor {
 	algr = strings.ToLower(algr)
-	if algr == "zstd" {
-		return ZStandard{ZSTD_LEVEL}
-	} else if algr == "lz4" {
-		return LZ4{}
-	} else if algr == "none" || algr == "" {
-		return noOp{}
+	if algr == "" {
+		algr = "none"
+	}
+	if f, ok := compressors[algr]; ok {
+		return f(params)
 	}
 	return nil
 }
 
+// IsNoop reports whether c stores data as is, so blocks can be read by range.
+func IsNoop(c Compressor) bool {
+	_, ok := c.(noOp)
+	return ok
+}
+
 type noOp struct{}
 
 func (n noOp) Name() string            { return "Noop" }
 func (n noOp) CompressBound(l int) int { return l }
 func (n noOp) Compress(dst, src []byte) (int, error) {
 	if len(dst) < len(src) {
-		return 0, fmt.Errorf("buffer too short: %d < %d", len(dst), len(src))
+		return 0, fmt.Errorf("%w: %d < %d", ErrBufferTooShort, len(dst), len(src))
 	}
 	copy(dst, src)
 	return len(src), nil
 }
 func (n noOp) Decompress(dst, src []byte) (int, error) {
 	if len(dst) < len(src) {
-		return 0, fmt.Errorf("buffer too short: %d < %d", len(dst), len(src))
+		return 0, fmt.Errorf("%w: %d < %d", ErrBufferTooShort, len(dst), len(src))
 	}
 	copy(dst, src)
 	return len(src), nil
@@ -84,20 +162,65 @@ func (n ZStandard) Compress(dst, src []byte) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	if len(d) > 0 && len(dst) > 0 && &d[0] != &dst[0] {
-		return 0, fmt.Errorf("buffer too short: %d < %d", cap(dst), cap(d))
+	if len(d) > 0 && (len(dst) == 0 || &d[0] != &dst[0]) {
+		return 0, fmt.Errorf("%w: %d < %d", ErrBufferTooShort, cap(dst), cap(d))
 	}
 	return len(d), err
 }
 
+// decoders keeps Zstd contexts for reuse on the read path. At most cap(decoders)
+// contexts are ever created, because their C memory is freed only by a finalizer.
+var (
+	decoders        = make(chan zstd.Ctx, runtime.GOMAXPROCS(0))
+	decodersCreated atomic.Int64
+)
+
+// getDecoder returns nil if all the decoders are in use.
+func getDecoder() zstd.Ctx {
+	select {
+	case c := <-decoders:
+		return c
+	default:
+	}
+	if decodersCreated.Load() < int64(cap(decoders)) && decodersCreated.Add(1) <= int64(cap(decoders)) {
+		return zstd.NewCtx()
+	}
+	return nil
+}
+
+func putDecoder(c zstd.Ctx) {
+	decoders <- c // never blocks, no more decoders than its capacity are created
+}
+
 // Decompress using Zstd
 func (n ZStandard) Decompress(dst, src []byte) (int, error) {
-	d, err := zstd.Decompress(dst, src)
+	ctx := getDecoder()
+	if ctx != nil {
+		defer putDecoder(ctx)
+	}
+	return zstdDecompress(ctx, dst, src)
+}
+
+// zstdDecompress decompresses with ctx, or without a context if ctx is nil
+func zstdDecompress(ctx zstd.Ctx, dst, src []byte) (int, error) {
+	if len(src) == 0 {
+		return 0, errEmptyInput
+	}
+	if len(src) >= len(zstdMagic) && !bytes.Equal(src[:len(zstdMagic)], zstdMagic) {
+		return 0, fmt.Errorf("%w: not a zstd frame", ErrWrongAlgorithm)
+	}
+	var d []byte
+	var err error
+	if ctx != nil {
+		d, err = ctx.Decompress(dst, src)
+	} else {
+		d, err = zstd.Decompress(dst, src)
+	}
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("%w: %w", ErrDecompress, err)
 	}
-	if len(d) > 0 && len(dst) > 0 && &d[0] != &dst[0] {
-		return 0, fmt.Errorf("buffer too short: %d < %d", len(dst), len(d))
+	if len(d) > 0 && (len(dst) == 0 || &d[0] != &dst[0]) {
+		return 0, fmt.Errorf("%w: %d < %d", ErrBufferTooShort, len(dst), len(d))
 	}
 	return len(d), err
 }
@@ -119,7 +242,12 @@ func (l LZ4) Compress(dst, src []byte) (int, error) {
 // Decompress using LZ4 algorithm
 func (l LZ4) Decompress(dst, src []byte) (int, error) {
 	if len(src) == 0 {
-		return 0, fmt.Errorf("decompress an empty input")
+		return 0, errEmptyInput
+	}
+	n, err := lz4.DecompressSafe(src, dst)
+	if err != nil {
+		// lz4 can't tell a malformed input from a short buffer
+		return 0, fmt.Errorf("%w: %w", ErrDecompress, err)
 	}
-	return lz4.DecompressSafe(src, dst)
+	return n, nil
 }
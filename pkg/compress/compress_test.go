@@ -17,9 +17,17 @@
 package compress
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"math/rand"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/DataDog/zstd"
 )
 
 func testCompress(t *testing.T, c Compressor) {
@@ -75,6 +83,155 @@ func TestLZ4(t *testing.T) {
 	testCompress(t, NewCompressor("lz4"))
 }
 
+func TestEmptyInput(t *testing.T) {
+	for _, algr := range []string{"none", "zstd", "lz4"} {
+		t.Run(algr, func(t *testing.T) {
+			c := NewCompressor(algr)
+			for _, src := range [][]byte{nil, {}} {
+				dst := make([]byte, c.CompressBound(0))
+				n, err := c.Compress(dst, src)
+				if err != nil {
+					t.Fatalf("compress empty input: %s", err)
+				}
+				if c.CompressBound(0) == 0 && n != 0 {
+					t.Fatalf("expect empty block, but got %d bytes", n)
+				}
+				for _, out := range [][]byte{nil, {}, make([]byte, 10)} {
+					m, err := c.Decompress(out, dst[:n])
+					if err != nil || m != 0 {
+						t.Fatalf("decompress empty block into %d bytes: %d %v", len(out), m, err)
+					}
+				}
+			}
+			if c.CompressBound(0) > 0 {
+				if n, err := c.Compress(nil, []byte("a")); err == nil {
+					t.Fatalf("expect short buffer error, but got %d", n)
+				}
+				block := make([]byte, c.CompressBound(1))
+				n, _ := c.Compress(block, []byte("a"))
+				if m, err := c.Decompress(nil, block[:n]); err == nil {
+					t.Fatalf("expect short buffer error, but got %d", m)
+				}
+				if _, err := c.Decompress(make([]byte, 10), nil); err != errEmptyInput {
+					t.Fatalf("expect %s, but got %v", errEmptyInput, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecompressErrors(t *testing.T) {
+	src := bytes.Repeat([]byte("JuiceFS compress "), 100)
+	for _, algr := range []string{"zstd", "lz4"} {
+		t.Run(algr, func(t *testing.T) {
+			c := NewCompressor(algr)
+			buf := make([]byte, c.CompressBound(len(src)))
+			n, err := c.Compress(buf, src)
+			if err != nil {
+				t.Fatalf("compress: %s", err)
+			}
+			block := buf[:n]
+			dst := make([]byte, len(src))
+
+			if _, err = c.Decompress(dst, block[:n/2]); !errors.Is(err, ErrDecompress) {
+				t.Fatalf("truncated: expect %s, but got %v", ErrDecompress, err)
+			}
+			corrupted := append([]byte{}, block...)
+			for i := len(corrupted) / 2; i < len(corrupted); i++ {
+				corrupted[i] = 0xff
+			}
+			if _, err = c.Decompress(dst, corrupted); !errors.Is(err, ErrDecompress) {
+				t.Fatalf("corrupted: expect %s, but got %v", ErrDecompress, err)
+			}
+			var code zstd.ErrorCode
+			if algr == "zstd" && !errors.As(err, &code) {
+				t.Fatalf("corrupted: expect zstd error code, but got %v", err)
+			}
+			for _, out := range [][]byte{nil, {}, make([]byte, 10)} {
+				n, err := c.Decompress(out, block)
+				if algr == "lz4" {
+					if !errors.Is(err, ErrDecompress) {
+						t.Fatalf("%d bytes buffer: expect %s, but got %d %v", len(out), ErrDecompress, n, err)
+					}
+				} else if !errors.Is(err, ErrBufferTooShort) {
+					t.Fatalf("%d bytes buffer: expect %s, but got %d %v", len(out), ErrBufferTooShort, n, err)
+				}
+			}
+		})
+	}
+	for _, out := range [][]byte{nil, make([]byte, 1)} {
+		if _, err := NewCompressor("none").Decompress(out, src); !errors.Is(err, ErrBufferTooShort) {
+			t.Fatalf("expect %s, but got %v", ErrBufferTooShort, err)
+		}
+	}
+
+	zc := NewCompressor("zstd")
+	buf := make([]byte, zc.CompressBound(len(src)))
+	n, _ := zc.Compress(buf, src)
+	buf[0] ^= 0xff
+	_, err := zc.Decompress(make([]byte, len(src)), buf[:n])
+	if !errors.Is(err, ErrWrongAlgorithm) || !errors.Is(err, ErrDecompress) {
+		t.Fatalf("corrupted header: expect %s, but got %v", ErrWrongAlgorithm, err)
+	}
+
+	lz4 := NewCompressor("lz4")
+	buf = make([]byte, lz4.CompressBound(len(src)))
+	n, _ = lz4.Compress(buf, src)
+	if _, err := zc.Decompress(make([]byte, len(src)), buf[:n]); !errors.Is(err, ErrWrongAlgorithm) {
+		t.Fatalf("expect %s, but got %v", ErrWrongAlgorithm, err)
+	}
+}
+
+// xorCodec is a codec whose output has the same size as its input
+type xorCodec struct{ key byte }
+
+func (x xorCodec) Name() string            { return "XOR" }
+func (x xorCodec) CompressBound(l int) int { return l }
+func (x xorCodec) Compress(dst, src []byte) (int, error) {
+	if len(dst) < len(src) {
+		return 0, fmt.Errorf("%w: %d < %d", ErrBufferTooShort, len(dst), len(src))
+	}
+	for i, b := range src {
+		dst[i] = b ^ x.key
+	}
+	return len(src), nil
+}
+func (x xorCodec) Decompress(dst, src []byte) (int, error) { return x.Compress(dst, src) }
+
+func TestRegisterCompressor(t *testing.T) {
+	RegisterCompressor("mycodec", func(params string) Compressor {
+		key := byte(0x5a)
+		if params != "" {
+			key = params[0]
+		}
+		return xorCodec{key}
+	})
+	defer delete(compressors, "mycodec")
+
+	c := NewCompressor("MyCodec")
+	if c == nil {
+		t.Fatal("registered compressor not found")
+	}
+	testCompress(t, c)
+	if NewCompressorWithParams("MYCODEC", "K").(xorCodec).key != 'K' {
+		t.Fatal("params are not passed to the factory as is")
+	}
+	if NewCompressor("unknown") != nil {
+		t.Fatal("unknown compressor should be nil")
+	}
+	if c := NewCompressorWithParams("zstd", "3"); c == nil || c.(ZStandard).level != 3 {
+		t.Fatalf("zstd with level: %+v", c)
+	}
+	if NewCompressor("zstd:3") != nil {
+		t.Fatal("params should not be accepted as part of the name")
+	}
+	for _, p := range [][2]string{{"zstd", "fast"}, {"lz4", "garbage"}, {"none", "1"}, {"", "1"}} {
+		if NewCompressorWithParams(p[0], p[1]) != nil {
+			t.Fatalf("invalid params %q for %q should be rejected", p[1], p[0])
+		}
+	}
+}
+
 func benchmarkDecompress(b *testing.B, comp Compressor) {
 	f, _ := os.Open(os.Getenv("PAYLOAD"))
 	var c = make([]byte, 5<<20)
@@ -116,6 +273,103 @@ func BenchmarkDecompressNone(b *testing.B) {
 	benchmarkDecompress(b, NewCompressor("none"))
 }
 
+// blockData generates text-like data, which compresses about 3:1 with zstd
+func blockData(size int) []byte {
+	words := strings.Fields("the quick brown fox jumps over lazy dog inode chunk slice block " +
+		"juicefs metadata object storage cache 0 1 2 3 4 5 6 7 8 9 / . , ;")
+	rng := rand.New(rand.NewSource(int64(size)))
+	var b bytes.Buffer
+	for b.Len() < size {
+		b.WriteString(words[rng.Intn(len(words))])
+		b.WriteByte(byte(' ' + rng.Intn(3)))
+	}
+	return b.Bytes()[:size]
+}
+
+func benchmarkZstdDecompress(b *testing.B, decompress func(dst, src []byte) (int, error)) {
+	c := NewCompressor("zstd")
+	for _, size := range []int{128 << 10, 1 << 20, 4 << 20} {
+		b.Run(fmt.Sprintf("%dK", size>>10), func(b *testing.B) {
+			d := blockData(size)
+			block := make([]byte, c.CompressBound(len(d)))
+			n, err := c.Compress(block, d)
+			if err != nil {
+				b.Fatalf("compress: %s", err)
+			}
+			block = block[:n]
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				out := make([]byte, size)
+				for pb.Next() {
+					if _, err := decompress(out, block); err != nil {
+						b.Errorf("decompress: %s", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkZstdDecompressPooled(b *testing.B) {
+	benchmarkZstdDecompress(b, ZStandard{}.Decompress)
+}
+
+func BenchmarkZstdDecompressNoPool(b *testing.B) {
+	benchmarkZstdDecompress(b, func(dst, src []byte) (int, error) { return zstdDecompress(nil, dst, src) })
+}
+
+func TestDecoderPool(t *testing.T) {
+	src := blockData(64 << 10)
+	c := NewCompressor("zstd")
+	block := make([]byte, c.CompressBound(len(src)))
+	n, _ := c.Compress(block, src)
+	block = block[:n]
+
+	// drain the pool, creating decoders up to its size
+	var taken []zstd.Ctx
+	for ctx := getDecoder(); ctx != nil; ctx = getDecoder() {
+		taken = append(taken, ctx)
+	}
+	if len(taken) != cap(decoders) {
+		t.Fatalf("expect %d decoders, but got %d", cap(decoders), len(taken))
+	}
+	out := make([]byte, len(src))
+	if m, err := c.Decompress(out, block); err != nil || !bytes.Equal(out[:m], src) {
+		t.Fatalf("decompress without decoder: %d %v", m, err)
+	}
+	putDecoder(taken[0])
+	if getDecoder() != taken[0] {
+		t.Fatal("idle decoder is not reused")
+	}
+	for _, ctx := range taken {
+		putDecoder(ctx)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8*cap(decoders); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out := make([]byte, len(src))
+			for j := 0; j < 10; j++ {
+				if m, err := c.Decompress(out, block); err != nil || !bytes.Equal(out[:m], src) {
+					t.Errorf("decompress: %d %v", m, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if created := decodersCreated.Load(); created > int64(cap(decoders)) {
+		t.Fatalf("%d decoders created, more than the pool size %d", created, cap(decoders))
+	}
+	if idle := len(decoders); int64(idle) != decodersCreated.Load() {
+		t.Fatalf("expect all %d decoders back in the pool, but got %d", decodersCreated.Load(), idle)
+	}
+}
+
 func benchmarkCompress(b *testing.B, comp Compressor) {
 	f, _ := os.Open(os.Getenv("PAYLOAD"))
 	var d = make([]byte, 4<<20)